@@ -0,0 +1,86 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+)
+
+const (
+	wrapperDecls = "package p;"
+	wrapperStmts = "package p; func _() { "
+	wrapperExpr  = "package p; var _ = "
+)
+
+// parseFragment parses exprStr, compiled by compileExpr, as whichever kind
+// of Go fragment it turns out to be: a full file, a set of declarations, a
+// statement or statement list, or a bare expression. It tries each wrapping
+// in turn, from least to most, and returns the node from the smallest
+// wrapper that parsed cleanly, with the wrapper itself stripped away so
+// search only ever sees the node kind the pattern was meant to describe.
+//
+// This borrows the fragment-recovery trick goimports-style tools use: a
+// pattern like "func $f($$args) error { $$body }" is a valid declaration
+// but not a valid file on its own, while "if $x != nil { return $x }" needs
+// a surrounding function body to parse as a statement at all. If every
+// wrapping fails, the error from the final (most permissive) attempt is
+// returned with its position translated back into exprStr's own
+// coordinates, so it still points at what the user actually typed rather
+// than at an offset into one of the wrappers above.
+func parseFragment(exprStr string) (ast.Node, error) {
+	fset := token.NewFileSet()
+
+	if f, err := parser.ParseFile(fset, "", exprStr, 0); err == nil {
+		return f, nil
+	}
+
+	if f, err := parser.ParseFile(fset, "", wrapperDecls+exprStr, 0); err == nil {
+		if len(f.Decls) == 1 {
+			return f.Decls[0], nil
+		}
+		return f, nil
+	}
+
+	if f, err := parser.ParseFile(fset, "", wrapperStmts+exprStr+"\n}", 0); err == nil {
+		body := f.Decls[0].(*ast.FuncDecl).Body
+		if len(body.List) == 1 {
+			return body.List[0], nil
+		}
+		return body, nil
+	}
+
+	f, err := parser.ParseFile(fset, "", wrapperExpr+exprStr, 0)
+	if err != nil {
+		return nil, repositionError(err, len(wrapperExpr), exprStr)
+	}
+	return f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0], nil
+}
+
+// repositionError rewrites err's positions, which point into a string with
+// prefixLen bytes of wrapper prepended to orig, so they point into orig
+// itself instead.
+func repositionError(err error, prefixLen int, orig string) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return err
+	}
+	origFset := token.NewFileSet()
+	origFile := origFset.AddFile("", -1, len(orig)+1)
+	origFile.SetLinesForContent([]byte(orig))
+	out := make(scanner.ErrorList, len(list))
+	for i, e := range list {
+		offset := e.Pos.Offset - prefixLen
+		if offset < 0 {
+			offset = 0
+		} else if offset > len(orig) {
+			offset = len(orig)
+		}
+		pos := origFile.Position(token.Pos(origFile.Base() + offset))
+		out[i] = &scanner.Error{Pos: pos, Msg: e.Msg}
+	}
+	return out
+}
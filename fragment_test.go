@@ -0,0 +1,71 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/scanner"
+	"strings"
+	"testing"
+)
+
+func TestParseFragmentKinds(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // the concrete type parseFragment should return
+	}{
+		{"if x != nil { return x }", "*ast.IfStmt"},
+		{"x := f(); g(x)", "*ast.BlockStmt"},
+		{"func f() error { return nil }", "*ast.FuncDecl"},
+		{"package p\n\nfunc f() {}\n", "*ast.File"},
+	}
+	for _, test := range tests {
+		node, err := parseFragment(test.in)
+		if err != nil {
+			t.Errorf("parseFragment(%q) error: %v", test.in, err)
+			continue
+		}
+		if got := nodeTypeName(node); got != test.want {
+			t.Errorf("parseFragment(%q) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func nodeTypeName(n ast.Node) string {
+	switch n.(type) {
+	case *ast.BinaryExpr:
+		return "*ast.BinaryExpr"
+	case *ast.IfStmt:
+		return "*ast.IfStmt"
+	case *ast.BlockStmt:
+		return "*ast.BlockStmt"
+	case *ast.FuncDecl:
+		return "*ast.FuncDecl"
+	case *ast.File:
+		return "*ast.File"
+	default:
+		return "unknown"
+	}
+}
+
+func TestParseFragmentErrorPosition(t *testing.T) {
+	_, err := parseFragment("x := \n")
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+	// The error should point somewhere within the fragment itself, not at
+	// an offset shifted by one of the "package p; ..." wrappers we try
+	// internally.
+	if strings.Contains(err.Error(), "package p") {
+		t.Errorf("error leaked wrapper text: %v", err)
+	}
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		t.Fatalf("error is %T, want a non-empty scanner.ErrorList", err)
+	}
+	// The ":=" sits at column 3 of the fragment.
+	if pos := list[0].Pos; pos.Line != 1 || pos.Column != 3 {
+		t.Errorf("error at %d:%d, want 1:3", pos.Line, pos.Column)
+	}
+}
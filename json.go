@@ -0,0 +1,107 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/token"
+	"os"
+	"strings"
+)
+
+var (
+	jsonOutput = flag.Bool("json", false, "print matches as a single JSON array instead of text")
+	jsonStream = flag.Bool("json-stream", false, "print matches as newline-delimited JSON (JSONL), one object per match as it is found")
+)
+
+// jsonAccum collects matches for -json, which needs the whole result set
+// before it can print a well-formed array; -json-stream instead encodes
+// each record as soon as it's found, so it never touches this.
+var jsonAccum []jsonRecord
+
+type jsonPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+type jsonBinding struct {
+	Text  string  `json:"text"`
+	Kind  string  `json:"kind"`
+	Start jsonPos `json:"start"`
+	End   jsonPos `json:"end"`
+}
+
+type jsonRecord struct {
+	Filename    string                 `json:"filename"`
+	RelFilename string                 `json:"relFilename"`
+	Start       jsonPos                `json:"start"`
+	End         jsonPos                `json:"end"`
+	Kind        string                 `json:"kind"`
+	Source      string                 `json:"source"`
+	Bindings    map[string]jsonBinding `json:"bindings,omitempty"`
+}
+
+func toJSONPos(p token.Position) jsonPos {
+	return jsonPos{Line: p.Line, Column: p.Column, Offset: p.Offset}
+}
+
+func toJSONRecord(fset *token.FileSet, wd string, mt match) jsonRecord {
+	start := fset.Position(mt.node.Pos())
+	rel := start.Filename
+	if strings.HasPrefix(rel, wd) {
+		rel = rel[len(wd)+1:]
+	}
+	rec := jsonRecord{
+		Filename:    start.Filename,
+		RelFilename: rel,
+		Start:       toJSONPos(start),
+		End:         toJSONPos(fset.Position(mt.node.End())),
+		Kind:        fmt.Sprintf("%T", mt.node),
+		Source:      singleLinePrint(mt.node),
+	}
+	if len(mt.values) > 0 {
+		rec.Bindings = make(map[string]jsonBinding, len(mt.values))
+		for name, node := range mt.values {
+			rec.Bindings["$"+name] = jsonBinding{
+				Text:  singleLinePrint(node),
+				Kind:  fmt.Sprintf("%T", node),
+				Start: toJSONPos(fset.Position(node.Pos())),
+				End:   toJSONPos(fset.Position(node.End())),
+			}
+		}
+	}
+	return rec
+}
+
+// emitJSONMatch handles one match under -json/-json-stream; it reports
+// whether it consumed the match (so the caller falls back to text output
+// when neither flag is set).
+func emitJSONMatch(fset *token.FileSet, wd string, mt match) bool {
+	switch {
+	case *jsonStream:
+		json.NewEncoder(os.Stdout).Encode(toJSONRecord(fset, wd, mt))
+	case *jsonOutput:
+		jsonAccum = append(jsonAccum, toJSONRecord(fset, wd, mt))
+	default:
+		return false
+	}
+	return true
+}
+
+// flushJSONArray prints the matches accumulated for -json; it is a no-op
+// for -json-stream, which has already printed each record as found.
+func flushJSONArray() {
+	if !*jsonOutput || *jsonStream {
+		return
+	}
+	if jsonAccum == nil {
+		// A zero-match run must still print [], not the bare "null" that
+		// encoding/json gives a nil slice, so jq '.[]' et al don't choke.
+		jsonAccum = []jsonRecord{}
+	}
+	json.NewEncoder(os.Stdout).Encode(jsonAccum)
+}
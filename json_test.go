@@ -0,0 +1,55 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestFlushJSONArrayEmptyIsNotNull(t *testing.T) {
+	oldAccum, oldOutput, oldStream := jsonAccum, *jsonOutput, *jsonStream
+	defer func() {
+		jsonAccum, *jsonOutput, *jsonStream = oldAccum, oldOutput, oldStream
+	}()
+	jsonAccum = nil
+	*jsonOutput = true
+	*jsonStream = false
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = w
+	flushJSONArray()
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatalf("reading pipe: %v", err)
+	}
+	got := bytes.TrimSpace(buf.Bytes())
+	if string(got) != "[]" {
+		t.Errorf("flushJSONArray with no matches printed %q, want %q", got, "[]")
+	}
+	var arr []jsonRecord
+	if err := json.Unmarshal(got, &arr); err != nil {
+		t.Errorf("output doesn't decode as a JSON array: %v", err)
+	}
+}
+
+func TestToJSONPos(t *testing.T) {
+	p := token.Position{Filename: "f.go", Line: 3, Column: 5, Offset: 42}
+	got := toJSONPos(p)
+	want := jsonPos{Line: 3, Column: 5, Offset: 42}
+	if got != want {
+		t.Errorf("toJSONPos(%+v) = %+v, want %+v", p, got, want)
+	}
+}
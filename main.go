@@ -10,6 +10,7 @@ import (
 	"go/ast"
 	"go/printer"
 	"go/token"
+	"go/types"
 	"os"
 	"regexp"
 	"strings"
@@ -19,38 +20,96 @@ import (
 	"github.com/kisielk/gotool"
 )
 
+var writeInPlace = flag.Bool("w", false, "write rewrite results back to their source files instead of printing a diff")
+
+var pipelineStages []rawStage
+
 func main() {
+	flag.Var(stageFlag{stageMatch, &pipelineStages}, "x", "match `pattern` (repeatable; later -x stages search within prior matches)")
+	flag.Var(stageFlag{stageKeep, &pipelineStages}, "g", "keep only matches containing `pattern`")
+	flag.Var(stageFlag{stageDrop, &pipelineStages}, "v", "drop matches containing `pattern`")
+	flag.Var(stageFlag{stageType, &pipelineStages}, "a", "keep only matches whose node has `type-expr`")
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, `usage: gogrep pattern [pkg ...]
+       gogrep pattern -> replacement [pkg ...]
+       gogrep -x pattern [-g pattern] [-v pattern] [-a type-expr] ... [pkg ...]
 
 A pattern is a Go expression or statement which may include wildcards.
+A wildcard may carry a type constraint, e.g. $x:int, $x:io.Reader,
+$x:implements(io.Closer) or $x:assignable-to(io.Writer), checked against
+the loaded packages' type information.
 
 Example:
 
 	gogrep 'if $x != nil { return $x }'
+
+In the second form, every match of pattern is rewritten to replacement,
+splicing in any wildcards captured from the match. By default the result
+is printed as a diff; pass -w to overwrite the source files instead.
+
+	gogrep -w 'if $x != nil { return $x }' -> 'if $x != nil { return fmt.Errorf("%w", $x) }'
+
+In the third form, -x/-g/-v/-a stages run left to right against the
+current match set, sharing the wildcard bindings captured so far:
+
+	gogrep -x 'if $x != nil { return $x }' -v 'return err'
+
+Pass -json to print all matches as one JSON array, or -json-stream to
+print one JSON object per match as it is found (JSONL), for consumption
+by editors, CI scripts, or jq.
+
+In the first form, files are searched in parallel across -j worker
+goroutines (default runtime.NumCPU()); pass -j 1 to search serially, e.g.
+while debugging. The second and third forms search files one at a time.
 `)
 	}
 	flag.Parse()
 	args := flag.Args()
+	if len(pipelineStages) > 0 {
+		if err := pipelineArgs(pipelineStages, args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		flushJSONArray()
+		return
+	}
 	if len(args) < 1 {
 		fmt.Fprintln(os.Stderr, "need at least one arg")
 		os.Exit(2)
 	}
-	if err := grepArgs(args[0], args[1:]); err != nil {
+	expr := args[0]
+	rest := args[1:]
+	var replExpr string
+	if len(rest) >= 2 && rest[0] == "->" {
+		replExpr = rest[1]
+		rest = rest[2:]
+	}
+	if err := grepArgs(expr, replExpr, rest); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	flushJSONArray()
 }
 
-func grepArgs(expr string, args []string) error {
-	exprNode, err := compileExpr(expr)
+func grepArgs(expr, replExpr string, args []string) error {
+	exprNode, constraints, err := compileExpr(expr)
 	if err != nil {
 		return err
 	}
+	var replNode ast.Node
+	if replExpr != "" {
+		replNode, _, err = compileExpr(replExpr)
+		if err != nil {
+			return err
+		}
+	}
 	paths := gotool.ImportPaths(args)
+	// Typed wildcards such as $x:int or $x:io.Reader need full type
+	// information, including inside function bodies, so unlike a plain
+	// syntactic grep we can no longer skip type-checking them.
 	conf := loader.Config{
 		TypeCheckFuncBodies: func(path string) bool {
-			return false
+			return true
 		},
 	}
 	if _, err := conf.FromArgs(paths, true); err != nil {
@@ -61,21 +120,43 @@ func grepArgs(expr string, args []string) error {
 		return err
 	}
 	wd, _ := os.Getwd()
-	for _, pkg := range prog.InitialPackages() {
-		for _, file := range pkg.Files {
-			matches := search(exprNode, file)
-			for _, n := range matches {
-				fpos := conf.Fset.Position(n.Pos())
-				if strings.HasPrefix(fpos.Filename, wd) {
-					fpos.Filename = fpos.Filename[len(wd)+1:]
+	if replNode != nil {
+		// Rewriting mutates files on disk (or at least diffs against
+		// them), so each file is handled serially rather than farmed
+		// out to the -j worker pool.
+		for _, pkg := range prog.InitialPackages() {
+			for _, file := range pkg.Files {
+				matches := filterTyped(conf.Fset, search(exprNode, file, &pkg.Info), constraints, &pkg.Info, pkg.Pkg)
+				if err := rewriteFile(conf.Fset, file, matches, replNode, wd, *writeInPlace); err != nil {
+					return err
 				}
-				fmt.Printf("%v: %s\n", fpos, singleLinePrint(n))
 			}
 		}
+		return nil
+	}
+	if *jsonStream {
+		searchStream(prog, exprNode, constraints, *numWorkers, func(ms []match) {
+			printMatches(conf.Fset, wd, ms)
+		})
+		return nil
 	}
+	printMatches(conf.Fset, wd, searchAll(prog, exprNode, constraints, *numWorkers))
 	return nil
 }
 
+func printMatches(fset *token.FileSet, wd string, matches []match) {
+	for _, mt := range matches {
+		if emitJSONMatch(fset, wd, mt) {
+			continue
+		}
+		fpos := fset.Position(mt.node.Pos())
+		if strings.HasPrefix(fpos.Filename, wd) {
+			fpos.Filename = fpos.Filename[len(wd)+1:]
+		}
+		fmt.Printf("%v: %s\n", fpos, singleLinePrint(mt.node))
+	}
+}
+
 type bufferJoinLines struct {
 	bytes.Buffer
 	last string
@@ -103,10 +184,17 @@ func singleLinePrint(node ast.Node) string {
 	return buf.String()
 }
 
-func compileExpr(expr string) (ast.Node, error) {
+// compileExpr compiles a pattern into its AST, along with any type
+// constraints ($x:int, $x:io.Reader, $x:implements(io.Closer),
+// $x:assignable-to(io.Writer)) attached to its wildcards. The constraints
+// are stripped out before tokenizing, so the tokenizer and matcher only
+// ever see plain $x wildcards; callers check the constraints separately
+// against the bindings a match produces (see filterTyped).
+func compileExpr(expr string) (ast.Node, wildcardConstraints, error) {
+	expr, constraints := extractTypeConstraints(expr)
 	toks, err := tokenize(expr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse expr: %v", err)
+		return nil, nil, fmt.Errorf("cannot parse expr: %v", err)
 	}
 	var buf bytes.Buffer
 	for _, t := range toks {
@@ -126,25 +214,41 @@ func compileExpr(expr string) (ast.Node, error) {
 	}
 	// trailing newlines can cause issues with commas
 	exprStr := strings.TrimSpace(buf.String())
-	node, err := parse(exprStr)
+	node, err := parseFragment(exprStr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse expr: %v", err)
+		return nil, nil, fmt.Errorf("cannot parse expr: %v", err)
 	}
-	return node, nil
+	return node, constraints, nil
+}
+
+// match pairs a matched node with the wildcard bindings captured while
+// matching it, keyed by wildcard name (e.g. "x" for $x). isList records
+// whether node came from exprLists rather than directly from ast.Inspect:
+// such a match's Pos()/End() span several consecutive elements of a real
+// list field (e.g. two of a call's three arguments) that coincide with one
+// of those elements' own position, which matters to callers like rewriteFile
+// that splice by position.
+type match struct {
+	node   ast.Node
+	values map[string]ast.Node
+	isList bool
 }
 
-func search(exprNode, node ast.Node) []ast.Node {
-	var matches []ast.Node
-	match := func(node ast.Node) {
-		m := matcher{values: map[string]ast.Node{}}
+// search walks node looking for subtrees matching exprNode, capturing each
+// wildcard's bound node in info's scope. It does not itself check wildcard
+// type constraints (e.g. $x:int); callers filter those with filterTyped.
+func search(exprNode, node ast.Node, info *types.Info) []match {
+	var matches []match
+	try := func(node ast.Node, isList bool) {
+		m := matcher{values: map[string]ast.Node{}, info: info}
 		if m.node(exprNode, node) {
-			matches = append(matches, node)
+			matches = append(matches, match{node: node, values: m.values, isList: isList})
 		}
 	}
 	ast.Inspect(node, func(node ast.Node) bool {
-		match(node)
+		try(node, false)
 		for _, list := range exprLists(node) {
-			match(list)
+			try(list, true)
 		}
 		return true
 	})
@@ -0,0 +1,304 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+)
+
+// matcher holds the wildcard bindings accumulated while walking a pattern
+// against a candidate node. A fresh matcher (and a fresh values map) is
+// used for every candidate position search and seededSearch try, except
+// that seededSearch pre-populates values from a prior stage's bindings, so
+// a wildcard reused across -x/-g/-v pipeline stages must unify rather than
+// silently rebind.
+type matcher struct {
+	values map[string]ast.Node
+	info   *types.Info
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+// node reports whether pattern structurally matches node, ignoring
+// positions, binding each wildcard identifier (see wildPrefix) to the node
+// it lines up with. A wildcard already bound (by an earlier occurrence in
+// this pattern, or by a seeded prior stage) must match the same node again,
+// rather than being free to rebind.
+func (m *matcher) node(pattern, node ast.Node) bool {
+	if pattern == nil || node == nil {
+		return pattern == nil && node == nil
+	}
+	if id, ok := pattern.(*ast.Ident); ok {
+		if name, _ := wildcardName(id.Name); name != "" {
+			return m.bind(name, node)
+		}
+	}
+	if list, ok := node.(nodeList); ok {
+		return m.matchList(pattern, list)
+	}
+	if reflect.TypeOf(pattern) != reflect.TypeOf(node) {
+		return false
+	}
+	pv, nv := reflect.ValueOf(pattern), reflect.ValueOf(node)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || nv.IsNil() {
+		return pv.Interface() == nv.Interface()
+	}
+	// Compare the pointed-to structs' fields directly, rather than through
+	// value: value re-checks its argument for a wildcard before doing
+	// anything else, and pattern/node have already cleared that check once
+	// above, so routing back through it here would just recurse forever.
+	return m.fields(pv.Elem(), nv.Elem())
+}
+
+// fields compares the two structs' fields (e.g. a *ast.CallExpr's Fun and
+// Args), routing each one through value so a wildcard nested in a field
+// typed as a concrete *ast.Ident (not boxed in an ast.Expr/ast.Stmt
+// interface, e.g. a *ast.SelectorExpr's Sel) is still recognized.
+func (m *matcher) fields(pv, nv reflect.Value) bool {
+	t := pv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// Obj links an Ident back to its declaration, which can cycle
+		// through the very node being compared; positions are just
+		// coordinates, not syntax. Neither belongs in a shape check.
+		if field.Name == "Obj" || field.Type == posType {
+			continue
+		}
+		if !m.value(pv.Field(i), nv.Field(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// bind records that name matched node, or, if name was already bound
+// (by an earlier wildcard occurrence or a seeded stage), requires node to
+// structurally match that earlier binding instead of rebinding it.
+func (m *matcher) bind(name string, node ast.Node) bool {
+	if name == "_" {
+		return true
+	}
+	if prev, ok := m.values[name]; ok {
+		return (&matcher{values: map[string]ast.Node{}, info: m.info}).node(prev, node)
+	}
+	m.values[name] = node
+	return true
+}
+
+// value compares two reflect.Values of identical type, recursing through
+// structs and slices field by field, routing anything that's itself an
+// ast.Node back through node so embedded wildcards are still recognized.
+func (m *matcher) value(pv, nv reflect.Value) bool {
+	if pv.Kind() == reflect.Interface {
+		if pv.IsNil() || nv.IsNil() {
+			return pv.IsNil() == nv.IsNil()
+		}
+		pv, nv = pv.Elem(), nv.Elem()
+	}
+	if pn, ok := asNode(pv); ok {
+		nn, ok := asNode(nv)
+		return ok && m.node(pn, nn)
+	}
+	switch pv.Kind() {
+	case reflect.Ptr:
+		if pv.IsNil() || nv.IsNil() {
+			return pv.IsNil() == nv.IsNil()
+		}
+		return m.value(pv.Elem(), nv.Elem())
+	case reflect.Struct:
+		if pv.Type() != nv.Type() {
+			return false
+		}
+		return m.fields(pv, nv)
+	case reflect.Slice:
+		return m.slice(pv, nv)
+	default:
+		return pv.Interface() == nv.Interface()
+	}
+}
+
+// slice compares two slices element by element, except that at most one
+// element of pv may be a $*name wildcard (e.g. f($x, $*rest)): it consumes
+// however many of nv's elements are left over once the elements before and
+// after it are matched positionally, bound as a single nodeList value.
+func (m *matcher) slice(pv, nv reflect.Value) bool {
+	wildIdx, wildName := -1, ""
+	for i := 0; i < pv.Len(); i++ {
+		pn, ok := asNode(pv.Index(i))
+		if !ok {
+			continue
+		}
+		id, ok := pn.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		if name, any := wildcardName(id.Name); name != "" && any {
+			if wildIdx != -1 {
+				return false // at most one $*wildcard per list is supported
+			}
+			wildIdx, wildName = i, name
+		}
+	}
+	if wildIdx == -1 {
+		if pv.Len() != nv.Len() {
+			return false
+		}
+		for i := 0; i < pv.Len(); i++ {
+			if !m.value(pv.Index(i), nv.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	after := pv.Len() - wildIdx - 1
+	if nv.Len() < pv.Len()-1 {
+		return false
+	}
+	for i := 0; i < wildIdx; i++ {
+		if !m.value(pv.Index(i), nv.Index(i)) {
+			return false
+		}
+	}
+	for i := 0; i < after; i++ {
+		if !m.value(pv.Index(pv.Len()-1-i), nv.Index(nv.Len()-1-i)) {
+			return false
+		}
+	}
+	return m.bindList(wildName, nv.Slice(wildIdx, nv.Len()-after))
+}
+
+// matchList matches pattern against a nodeList candidate produced by
+// exprLists. node already routes a bare $*name pattern to bind before ever
+// calling this, so what's left is a pattern whose own list-shaped field
+// (e.g. a block's statements, a call's arguments) can be compared
+// slice-wise against list's elements, such as a 2-statement pattern
+// matching a 2-statement run inside a longer block.
+func (m *matcher) matchList(pattern ast.Node, list nodeList) bool {
+	field, ok := nodeSliceField(pattern)
+	if !ok {
+		return false
+	}
+	return m.slice(field, reflect.ValueOf([]ast.Node(list)))
+}
+
+// bindList is bind's counterpart for a $*name wildcard that matched zero or
+// more elements of a list field at once, rather than a single node.
+func (m *matcher) bindList(name string, nv reflect.Value) bool {
+	list := make(nodeList, nv.Len())
+	for i := range list {
+		n, _ := asNode(nv.Index(i))
+		list[i] = n
+	}
+	return m.bind(name, list)
+}
+
+// asNode reports whether v's value implements ast.Node, unwrapping the
+// interface value itself (not its underlying concrete value) so callers
+// can route it back through node/matchList.
+func asNode(v reflect.Value) (ast.Node, bool) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	n, ok := v.Interface().(ast.Node)
+	if !ok || n == nil || reflect.ValueOf(n).IsNil() {
+		return nil, false
+	}
+	return n, ok
+}
+
+// nodeSliceField finds the single []ast.Stmt or []ast.Expr-shaped field of
+// pattern (e.g. a *ast.BlockStmt's List, a *ast.CallExpr's Args) that a
+// nodeList candidate can be compared against, so a statement- or
+// expression-list pattern can match a contiguous run found by exprLists
+// inside a larger list, not just a field-for-field equal one.
+func nodeSliceField(pattern ast.Node) (reflect.Value, bool) {
+	v := reflect.ValueOf(pattern)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return reflect.Value{}, false
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.Slice {
+			continue
+		}
+		elem := f.Type().Elem()
+		if elem == reflect.TypeOf((*ast.Stmt)(nil)).Elem() ||
+			elem == reflect.TypeOf((*ast.Expr)(nil)).Elem() {
+			return f, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// nodeList is a contiguous run of nodes from a slice field (e.g. a call's
+// arguments, or a block's statements), treated as a single candidate so a
+// pattern ending in a $*name wildcard can match "zero or more" list
+// elements, or a statement-list pattern can match a sub-run of a longer
+// one. Its Pos/End span the same range as the underlying elements, which is
+// why a match built from it (match.isList) needs special handling from
+// rewriteFile instead of being spliced in by position like an ordinary
+// single-node match.
+type nodeList []ast.Node
+
+func (l nodeList) Pos() token.Pos {
+	if len(l) == 0 {
+		return token.NoPos
+	}
+	return l[0].Pos()
+}
+
+func (l nodeList) End() token.Pos {
+	if len(l) == 0 {
+		return token.NoPos
+	}
+	return l[len(l)-1].End()
+}
+
+// exprLists returns, for every []ast.Stmt or []ast.Expr field of node (e.g.
+// a block's statements or a call's arguments), every contiguous run of two
+// or more of its elements, as a nodeList candidate for search/seededSearch
+// to try matching exprNode against. Runs of length 1 are skipped: those
+// positions are already visited directly by ast.Inspect, so trying them
+// again here would just duplicate that match.
+func exprLists(node ast.Node) []nodeList {
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var lists []nodeList
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.Slice {
+			continue
+		}
+		elem := f.Type().Elem()
+		if elem != reflect.TypeOf((*ast.Stmt)(nil)).Elem() &&
+			elem != reflect.TypeOf((*ast.Expr)(nil)).Elem() {
+			continue
+		}
+		n := f.Len()
+		for start := 0; start < n; start++ {
+			for end := start + 2; end <= n; end++ {
+				list := make(nodeList, end-start)
+				for i := start; i < end; i++ {
+					node, _ := asNode(f.Index(i))
+					list[i-start] = node
+				}
+				lists = append(lists, list)
+			}
+		}
+	}
+	return lists
+}
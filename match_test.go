@@ -0,0 +1,125 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+// compile mimics compileExpr without the typed-wildcard bookkeeping, which
+// these tests don't exercise, so they stay focused on search/matcher.
+func compile(t *testing.T, s string) ast.Node {
+	t.Helper()
+	exprStr, _ := extractTypeConstraints(s)
+	toks, err := tokenize(exprStr)
+	if err != nil {
+		t.Fatalf("tokenize(%q): %v", s, err)
+	}
+	var out string
+	for _, tok := range toks {
+		switch {
+		case tok.tok == tokWild:
+			out += wildPrefix + tok.lit
+		case tok.tok == tokWildAny:
+			out += wildPrefix + wildExtraAny + tok.lit
+		case tok.lit != "":
+			out += tok.lit
+		default:
+			out += tok.tok.String()
+		}
+		out += " "
+	}
+	node, err := parseFragment(out)
+	if err != nil {
+		t.Fatalf("parseFragment(%q) from %q: %v", out, s, err)
+	}
+	return node
+}
+
+func parseSrc(t *testing.T, src string) *ast.File {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "f.go", "package p\n"+src, 0)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	return f
+}
+
+func TestSearchPlainWildcard(t *testing.T) {
+	pattern := compile(t, "if $x != nil { return $x }")
+	file := parseSrc(t, `
+func f() error {
+	var err error
+	if err != nil {
+		return err
+	}
+	if err != nil {
+		return nil
+	}
+	return nil
+}`)
+	matches := search(pattern, file, nil)
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(matches))
+	}
+	x, ok := matches[0].values["x"].(*ast.Ident)
+	if !ok || x.Name != "err" {
+		t.Errorf("$x bound to %#v, want ident \"err\"", matches[0].values["x"])
+	}
+}
+
+func TestSearchRepeatedWildcardMustUnify(t *testing.T) {
+	pattern := compile(t, "$x = $x")
+	file := parseSrc(t, `
+func f() {
+	a := 1
+	b := 2
+	a = a
+	a = b
+}`)
+	matches := search(pattern, file, nil)
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1 (only a = a should unify)", len(matches))
+	}
+}
+
+func TestSearchCallArgsAnyWildcard(t *testing.T) {
+	pattern := compile(t, "fmt.Sprintf($x, $*rest)")
+	file := parseSrc(t, `
+func f() {
+	fmt.Sprintf("a")
+	fmt.Sprintf("b", 1)
+	fmt.Sprintf("c", 1, 2)
+	fmt.Println("d", 1, 2)
+}`)
+	matches := search(pattern, file, nil)
+	if len(matches) != 3 {
+		t.Fatalf("got %d matches, want 3 (every Sprintf call, since $*rest matches zero or more)", len(matches))
+	}
+}
+
+func TestExprListsStatementRun(t *testing.T) {
+	pattern := compile(t, "x := f(); g(x)")
+	file := parseSrc(t, `
+func h() {
+	println("noise")
+	x := f()
+	g(x)
+	println("more noise")
+}`)
+	matches := search(pattern, file, nil)
+	var listMatches int
+	for _, mt := range matches {
+		if mt.isList {
+			listMatches++
+		}
+	}
+	if listMatches == 0 {
+		t.Fatalf("got %d list matches among %d total, want at least 1", listMatches, len(matches))
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"flag"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"runtime"
+	"sort"
+	"sync"
+
+	"golang.org/x/tools/go/loader"
+)
+
+var numWorkers = flag.Int("j", runtime.NumCPU(), "number of worker goroutines to search files with; 1 disables parallelism, e.g. for debugging")
+
+// fileJob is one file to search, paired with the type info and package of
+// the package it was loaded as part of.
+type fileJob struct {
+	file *ast.File
+	info *types.Info
+	pkg  *types.Package
+}
+
+func collectFiles(prog *loader.Program) []fileJob {
+	var jobs []fileJob
+	for _, pkg := range prog.InitialPackages() {
+		for _, file := range pkg.Files {
+			jobs = append(jobs, fileJob{file: file, info: &pkg.Info, pkg: pkg.Pkg})
+		}
+	}
+	return jobs
+}
+
+// searchJobs fans jobs out across workers goroutines, each running search
+// (filtered through constraints) on its own file, and streams each file's
+// matches back on the returned channel as soon as that file is done,
+// in completion order rather than job order. The channel is closed once
+// every job has reported.
+//
+// This is safe to parallelize because matcher allocates a fresh values map
+// per call, so it carries no state across files, and token.FileSet's
+// Position method is safe for concurrent use.
+func searchJobs(fset *token.FileSet, jobs []fileJob, exprNode ast.Node, constraints wildcardConstraints, workers int) <-chan []match {
+	if workers < 1 {
+		workers = 1
+	}
+	jobCh := make(chan int)
+	resultCh := make(chan []match)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobCh {
+				matches := search(exprNode, jobs[i].file, jobs[i].info)
+				resultCh <- filterTyped(fset, matches, constraints, jobs[i].info, jobs[i].pkg)
+			}
+		}()
+	}
+	go func() {
+		for i := range jobs {
+			jobCh <- i
+		}
+		close(jobCh)
+	}()
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+	return resultCh
+}
+
+// searchAll searches every file in prog for exprNode using workers
+// goroutines, and returns all matches sorted by filename and then position
+// so output stays deterministic regardless of scheduling order. Use this
+// for output modes (plain text, -json) that need the whole result set
+// before printing anything.
+func searchAll(prog *loader.Program, exprNode ast.Node, constraints wildcardConstraints, workers int) []match {
+	var all []match
+	for ms := range searchJobs(prog.Fset, collectFiles(prog), exprNode, constraints, workers) {
+		all = append(all, ms...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		pi, pj := prog.Fset.Position(all[i].node.Pos()), prog.Fset.Position(all[j].node.Pos())
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	return all
+}
+
+// searchStream is searchAll's counterpart for -json-stream: it calls emit
+// with each file's matches as soon as that file's search completes, rather
+// than waiting for and sorting the whole result set, so the first JSONL
+// line appears as soon as the first file is searched instead of after the
+// whole tree is.
+func searchStream(prog *loader.Program, exprNode ast.Node, constraints wildcardConstraints, workers int, emit func([]match)) {
+	for ms := range searchJobs(prog.Fset, collectFiles(prog), exprNode, constraints, workers) {
+		if len(ms) > 0 {
+			emit(ms)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+
+	"golang.org/x/tools/go/loader"
+
+	"github.com/kisielk/gotool"
+)
+
+var errFirstStage = fmt.Errorf("the first pipeline stage must be -x (a -g/-v/-a filter needs a match set to work on)")
+
+// stageKind identifies how a pipeline stage narrows the current match set.
+type stageKind int
+
+const (
+	stageMatch stageKind = iota // -x: search within each current match, replacing it with the submatch
+	stageKeep                   // -g: keep a match iff pattern also matches somewhere inside it
+	stageDrop                   // -v: drop a match iff pattern also matches somewhere inside it
+	stageType                   // -a: keep a match iff its node satisfies a type expression
+)
+
+// rawStage is one -x/-g/-v/-a flag occurrence, in command-line order.
+type rawStage struct {
+	kind stageKind
+	expr string
+}
+
+// stageFlag adapts a stageKind into a flag.Value that appends to stages,
+// so -x/-g/-v/-a can all be repeated and interleaved freely.
+type stageFlag struct {
+	kind   stageKind
+	stages *[]rawStage
+}
+
+func (f stageFlag) String() string { return "" }
+
+func (f stageFlag) Set(expr string) error {
+	*f.stages = append(*f.stages, rawStage{kind: f.kind, expr: expr})
+	return nil
+}
+
+// stage is a compiled rawStage, ready to run against a match set.
+type stage struct {
+	kind        stageKind
+	expr        string
+	node        ast.Node // compiled pattern; unused for stageType
+	constraints wildcardConstraints
+}
+
+func compileStages(raw []rawStage) ([]stage, error) {
+	stages := make([]stage, len(raw))
+	for i, r := range raw {
+		st := stage{kind: r.kind, expr: r.expr}
+		if r.kind != stageType {
+			node, constraints, err := compileExpr(r.expr)
+			if err != nil {
+				return nil, err
+			}
+			st.node = node
+			st.constraints = constraints
+		}
+		stages[i] = st
+	}
+	return stages, nil
+}
+
+// pipelineArgs loads pkgs and, for every file, runs the compiled -x/-g/-v/-a
+// stages against it in order, printing the surviving matches.
+func pipelineArgs(raw []rawStage, pkgs []string) error {
+	stages, err := compileStages(raw)
+	if err != nil {
+		return err
+	}
+	if stages[0].kind != stageMatch {
+		return errFirstStage
+	}
+	paths := gotool.ImportPaths(pkgs)
+	conf := loader.Config{
+		TypeCheckFuncBodies: func(path string) bool {
+			return true
+		},
+	}
+	if _, err := conf.FromArgs(paths, true); err != nil {
+		return err
+	}
+	prog, err := conf.Load()
+	if err != nil {
+		return err
+	}
+	wd, _ := os.Getwd()
+	for _, pkg := range prog.InitialPackages() {
+		for _, file := range pkg.Files {
+			matches := runPipeline(conf.Fset, stages, file, &pkg.Info, pkg.Pkg)
+			printMatches(conf.Fset, wd, matches)
+		}
+	}
+	return nil
+}
+
+// runPipeline evaluates stages against node, threading each surviving
+// match's wildcard bindings into the next stage so that e.g. $x captured in
+// an earlier -x stage must unify with $x in a later one.
+func runPipeline(fset *token.FileSet, stages []stage, node ast.Node, info *types.Info, pkg *types.Package) []match {
+	cur := filterTyped(fset, search(stages[0].node, node, info), stages[0].constraints, info, pkg)
+	for _, st := range stages[1:] {
+		var next []match
+		for _, mt := range cur {
+			switch st.kind {
+			case stageMatch:
+				// seededSearch pre-populates the matcher with mt's
+				// bindings, so a reused wildcard name (e.g. $x in both
+				// an earlier and this -x stage) must unify with its
+				// prior binding rather than being free to rebind.
+				next = append(next, filterTyped(fset, seededSearch(st.node, mt.node, info, mt.values), st.constraints, info, pkg)...)
+			case stageKeep:
+				if len(filterTyped(fset, seededSearch(st.node, mt.node, info, mt.values), st.constraints, info, pkg)) > 0 {
+					next = append(next, mt)
+				}
+			case stageDrop:
+				if len(filterTyped(fset, seededSearch(st.node, mt.node, info, mt.values), st.constraints, info, pkg)) == 0 {
+					next = append(next, mt)
+				}
+			case stageType:
+				if nodeHasType(fset, mt.node, st.expr, info, pkg) {
+					next = append(next, mt)
+				}
+			}
+		}
+		cur = next
+	}
+	return cur
+}
+
+// seededSearch is search, but each candidate match starts out with values
+// already bound, so a filter stage's wildcards must agree with prior stages.
+func seededSearch(exprNode, node ast.Node, info *types.Info, values map[string]ast.Node) []match {
+	var matches []match
+	try := func(node ast.Node, isList bool) {
+		m := matcher{values: mergeValues(values, nil), info: info}
+		if m.node(exprNode, node) {
+			matches = append(matches, match{node: node, values: m.values, isList: isList})
+		}
+	}
+	ast.Inspect(node, func(node ast.Node) bool {
+		try(node, false)
+		for _, list := range exprLists(node) {
+			try(list, true)
+		}
+		return true
+	})
+	return matches
+}
+
+func mergeValues(a, b map[string]ast.Node) map[string]ast.Node {
+	out := make(map[string]ast.Node, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+// nodeHasType reports whether node's static type satisfies typeExpr (e.g.
+// "error", "io.Reader"), resolved against pkg's scope with types.Eval.
+// fset must be the FileSet pkg was type-checked with, so that node.Pos()
+// (used as types.Eval's evaluation point) lands in one of its files: Eval
+// resolves a dotted identifier like "io.Reader" against that file's own
+// scope, where its imports live, not pkg's package-level scope, which never
+// sees per-file imports at all. A typeExpr of the form implements(T) or
+// assignable-to(T) checks only types.Implements or types.AssignableTo
+// against T; any other typeExpr accepts an identical, assignable or
+// implementing type.
+func nodeHasType(fset *token.FileSet, node ast.Node, typeExpr string, info *types.Info, pkg *types.Package) bool {
+	e, ok := node.(ast.Expr)
+	if !ok || info == nil {
+		return false
+	}
+	got := info.TypeOf(e)
+	if got == nil {
+		return false
+	}
+	mode := ""
+	for _, m := range []string{"implements", "assignable-to"} {
+		if n := len(m); len(typeExpr) > n+2 && typeExpr[:n+1] == m+"(" && typeExpr[len(typeExpr)-1] == ')' {
+			mode, typeExpr = m, typeExpr[n+1:len(typeExpr)-1]
+			break
+		}
+	}
+	tv, err := types.Eval(fset, pkg, node.Pos(), typeExpr)
+	if err != nil {
+		return false
+	}
+	switch mode {
+	case "implements":
+		iface, ok := tv.Type.Underlying().(*types.Interface)
+		return ok && types.Implements(got, iface)
+	case "assignable-to":
+		return types.AssignableTo(got, tv.Type)
+	}
+	return types.Identical(got, tv.Type) ||
+		types.AssignableTo(got, tv.Type) ||
+		(types.IsInterface(tv.Type) && types.Implements(got, tv.Type.Underlying().(*types.Interface)))
+}
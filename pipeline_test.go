@@ -0,0 +1,30 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"testing"
+)
+
+func TestMergeValues(t *testing.T) {
+	x := &ast.Ident{Name: "x"}
+	y := &ast.Ident{Name: "y"}
+	z := &ast.Ident{Name: "z"}
+
+	got := mergeValues(map[string]ast.Node{"x": x, "y": y}, map[string]ast.Node{"y": z})
+	if got["x"] != x {
+		t.Errorf(`merged["x"] = %v, want the value from a`, got["x"])
+	}
+	if got["y"] != z {
+		t.Errorf(`merged["y"] = %v, want b's value to win on collision`, got["y"])
+	}
+	if len(got) != 2 {
+		t.Errorf("merged has %d entries, want 2", len(got))
+	}
+
+	if got := mergeValues(nil, nil); len(got) != 0 {
+		t.Errorf("mergeValues(nil, nil) = %v, want empty", got)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// rewriteFile splices replNode into file at every position in matches,
+// substituting each match's captured wildcards into the replacement, then
+// either prints a unified diff of the result or writes it back to disk.
+//
+// List-context matches (isList) span several consecutive elements of a
+// slice field rather than a single node, which astutil.Cursor can't replace
+// in one step; rewriting those isn't supported yet, so they're reported and
+// skipped rather than silently splicing a whole-list replacement into one
+// element's slot.
+func rewriteFile(fset *token.FileSet, file *ast.File, matches []match, replNode ast.Node, wd string, inPlace bool) error {
+	if len(matches) == 0 {
+		return nil
+	}
+	byPos := make(map[token.Pos]match, len(matches))
+	for _, mt := range matches {
+		if mt.isList {
+			fmt.Fprintf(os.Stderr, "gogrep: %v: skipping list-context match (rewriting a list of nodes isn't supported yet)\n", fset.Position(mt.node.Pos()))
+			continue
+		}
+		byPos[mt.node.Pos()] = mt
+	}
+	if len(byPos) == 0 {
+		return nil
+	}
+
+	cmap := ast.NewCommentMap(fset, file, file.Comments)
+	astutil.Apply(file, nil, func(c *astutil.Cursor) bool {
+		n := c.Node()
+		if n == nil {
+			return true
+		}
+		// Several nested nodes can share a Pos (a statement and its first
+		// operand), so only the matched node itself is replaced.
+		mt, ok := byPos[n.Pos()]
+		if !ok || mt.node != n {
+			return true
+		}
+		c.Replace(substitute(replNode, mt.values))
+		return true
+	})
+	file.Comments = cmap.Filter(file).Comments()
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return fmt.Errorf("cannot format rewritten file: %v", err)
+	}
+
+	fpos := fset.Position(file.Pos())
+	name := fpos.Filename
+	if strings.HasPrefix(name, wd) {
+		name = name[len(wd)+1:]
+	}
+	if !inPlace {
+		return printDiff(name, buf.Bytes())
+	}
+	return ioutil.WriteFile(fpos.Filename, buf.Bytes(), 0o644)
+}
+
+// substitute returns a copy of replNode, positioned so it carries no stale
+// coordinates from the throwaway FileSet it was originally parsed with, with
+// every wildcard identifier (produced by compileExpr's wildPrefix naming)
+// replaced by its bound node from values (a $*name binding is spliced in
+// element by element, in the list the wildcard sits in). Those bound nodes keep their real
+// positions from the file being rewritten; only the replacement skeleton
+// itself needs its positions cleared, so the two don't collide when
+// format.Node walks the spliced-together tree.
+func substitute(replNode ast.Node, values map[string]ast.Node) ast.Node {
+	return astutil.Apply(cloneNode(replNode), nil, func(c *astutil.Cursor) bool {
+		id, ok := c.Node().(*ast.Ident)
+		if !ok {
+			return true
+		}
+		name, _ := wildcardName(id.Name)
+		v, ok := values[name]
+		if !ok {
+			return true
+		}
+		if list, ok := v.(nodeList); ok {
+			for i := len(list) - 1; i >= 0; i-- {
+				c.InsertAfter(list[i])
+			}
+			c.Delete()
+			return true
+		}
+		c.Replace(v)
+		return true
+	}).(ast.Node)
+}
+
+// cloneNode makes a textual round-trip copy of node with all positions
+// zeroed, so that substituting into it doesn't mutate the compiled pattern
+// shared across matches, and so format.Node doesn't mistake its positions
+// (from the throwaway FileSet node was first parsed with) for real
+// coordinates in whatever file it ends up spliced into.
+func cloneNode(node ast.Node) ast.Node {
+	var buf bytes.Buffer
+	fset := token.NewFileSet()
+	if err := format.Node(&buf, fset, node); err != nil {
+		// The node came from our own compileExpr, so it must be
+		// printable; a failure here is a bug, not a user error.
+		panic(fmt.Sprintf("cannot clone replacement node: %v", err))
+	}
+	clone, err := parseFragment(buf.String())
+	if err != nil {
+		panic(fmt.Sprintf("cannot reparse replacement node: %v", err))
+	}
+	clearPositions(clone)
+	return clone
+}
+
+// clearPositions zeroes every token.Pos-typed field in node's tree, so
+// go/printer treats it as having no position hints of its own.
+func clearPositions(node ast.Node) {
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return true
+		}
+		v = v.Elem()
+		if v.Kind() != reflect.Struct {
+			return true
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if f.Type() == posType && f.CanSet() {
+				f.SetInt(int64(token.NoPos))
+			}
+		}
+		return true
+	})
+}
+
+// printDiff writes a unified diff between the on-disk contents of name and
+// the rewritten contents in out, shelling out to the system's diff tool as
+// gofmt -d does.
+func printDiff(name string, out []byte) error {
+	orig, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(orig, out) {
+		return nil
+	}
+	tmp, err := ioutil.TempFile("", "gogrep-rewrite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	data, err := exec.Command("diff", "-u", name, tmp.Name()).CombinedOutput()
+	// diff exits with status 1 when the files differ; that's expected.
+	if err != nil && len(data) == 0 {
+		return fmt.Errorf("diff %s: %v", name, err)
+	}
+	os.Stdout.Write(bytes.Replace(data, []byte(tmp.Name()), []byte(name), 1))
+	return nil
+}
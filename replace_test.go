@@ -0,0 +1,32 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestClearPositions(t *testing.T) {
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", "f(x, g(y, 1))", 0)
+	if err != nil {
+		t.Fatalf("ParseExprFrom: %v", err)
+	}
+	if expr.Pos() == token.NoPos {
+		t.Fatal("expected the freshly parsed expression to have a real position")
+	}
+	clearPositions(expr)
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		if n.Pos() != token.NoPos {
+			t.Errorf("%T still has a non-zero Pos after clearPositions", n)
+		}
+		return true
+	})
+}
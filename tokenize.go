@@ -0,0 +1,114 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+)
+
+// tokWild and tokWildAny are synthetic token.Token values, outside the
+// range go/scanner ever produces, used to mark a $name or $*name wildcard
+// once tokenize has recognized the '$' sigil itself.
+const (
+	tokWild token.Token = token.Token(-(iota + 1))
+	tokWildAny
+)
+
+// wildPrefix turns a wildcard's name into a valid Go identifier so the
+// tokens tokenize emits can be fed straight through go/parser; wildExtraAny
+// further marks a $*name (rather than plain $name) wildcard. Both are
+// stripped back off by name lookups in the matcher and in replace.go.
+const (
+	wildPrefix   = "gogrep_"
+	wildExtraAny = "any_"
+)
+
+// exprToken is a single lexical token produced by tokenize: either a
+// wildcard ($x, $*x) or a token.Token straight from go/scanner, whichever
+// the scanner and the trailing $-sigil check produced.
+type exprToken struct {
+	tok token.Token
+	lit string
+}
+
+// illegalDollarMsg is the exact message go/scanner reports for the '$'
+// rune, which it has no token of its own for; tokenize handles '$' itself
+// (as the start of a wildcard) so that particular error is expected and
+// ignored, while any other scanner error still fails the tokenize call.
+var illegalDollarMsg = fmt.Sprintf("illegal character %#U", '$')
+
+// tokenize lexes a gogrep pattern with go/scanner, recognizing $name and
+// $*name wildcards in addition to ordinary Go tokens. The wildcard's name
+// (after the '$' and optional '*') is returned as exprToken.lit; compileExpr
+// turns it into a valid Go identifier by adding wildPrefix (and
+// wildExtraAny for the $* form) before handing the result to parseFragment.
+func tokenize(expr string) ([]exprToken, error) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(expr))
+	var errs scanner.ErrorList
+	var s scanner.Scanner
+	s.Init(file, []byte(expr), func(pos token.Position, msg string) {
+		if msg == illegalDollarMsg {
+			return
+		}
+		errs.Add(pos, msg)
+	}, scanner.ScanComments)
+
+	var toks []exprToken
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.COMMENT {
+			continue
+		}
+		if tok == token.ILLEGAL && lit == "$" {
+			any := false
+			_, tok2, lit2 := s.Scan()
+			if tok2 == token.MUL {
+				any = true
+				_, tok2, lit2 = s.Scan()
+			}
+			if tok2 != token.IDENT {
+				return nil, fmt.Errorf("expected a name after '$', got %v", tok2)
+			}
+			if any {
+				toks = append(toks, exprToken{tok: tokWildAny, lit: lit2})
+			} else {
+				toks = append(toks, exprToken{tok: tokWild, lit: lit2})
+			}
+			continue
+		}
+		toks = append(toks, exprToken{tok: tok, lit: lit})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return toks, nil
+}
+
+// wildcardName reports whether name (an *ast.Ident.Name produced by
+// compileExpr) encodes a wildcard, and if so, its bare name and whether it
+// was written as $*name (matching zero or more list elements) rather than
+// plain $name (matching exactly one node).
+func wildcardName(name string) (bare string, any bool) {
+	rest := trimPrefix(name, wildPrefix)
+	if rest == "" {
+		return "", false
+	}
+	if trimmed := trimPrefix(rest, wildExtraAny); trimmed != "" {
+		return trimmed, true
+	}
+	return rest, false
+}
+
+func trimPrefix(s, prefix string) string {
+	if len(s) <= len(prefix) || s[:len(prefix)] != prefix {
+		return ""
+	}
+	return s[len(prefix):]
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/token"
+	"go/types"
+)
+
+// wildcardConstraints maps a wildcard's name (e.g. "x" for $x) to the type
+// constraint text that followed its colon in the original pattern, such as
+// "int", "io.Reader", "implements(io.Closer)" or "assignable-to(io.Writer)".
+type wildcardConstraints map[string]string
+
+// extractTypeConstraints scans expr for "$name:constraint" wildcards,
+// strips the ":constraint" suffix so the rest of compileExpr sees a plain
+// "$name" wildcard as before, and returns the stripped constraints keyed by
+// name. The constraint text runs until the next unparenthesized space, tab,
+// newline, comma, semicolon or '}', or an unmatched ')' (a ']' does not end
+// it), so both bare constraints ($x:int) and
+// call-like ones ($x:implements(io.Closer)) are captured whole.
+func extractTypeConstraints(expr string) (string, wildcardConstraints) {
+	constraints := wildcardConstraints{}
+	var out []byte
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		if c != '$' {
+			out = append(out, c)
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(expr) && isIdentByte(expr[j]) {
+			j++
+		}
+		name := expr[i+1 : j]
+		if name == "" || j >= len(expr) || expr[j] != ':' {
+			out = append(out, c)
+			i++
+			continue
+		}
+		k := j + 1
+		start := k
+		depth := 0
+	scan:
+		for k < len(expr) {
+			switch expr[k] {
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					break scan
+				}
+				depth--
+			case ' ', '\t', '\n', ',', ';', '}':
+				if depth == 0 {
+					break scan
+				}
+			}
+			k++
+		}
+		constraint := expr[start:k]
+		if constraint == "" {
+			out = append(out, c)
+			i++
+			continue
+		}
+		constraints[name] = constraint
+		out = append(out, '$')
+		out = append(out, name...)
+		i = k
+	}
+	return string(out), constraints
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+// filterTyped keeps only the matches where every constrained wildcard's
+// bound node satisfies its type constraint, resolved against pkg's scope.
+// A constrained name with no binding (the wildcard didn't appear in the
+// pattern, or never matched) fails closed and drops the match.
+func filterTyped(fset *token.FileSet, matches []match, constraints wildcardConstraints, info *types.Info, pkg *types.Package) []match {
+	if len(constraints) == 0 {
+		return matches
+	}
+	var kept []match
+	for _, mt := range matches {
+		ok := true
+		for name, constraint := range constraints {
+			bound, have := mt.values[name]
+			if !have || !nodeHasType(fset, bound, constraint, info, pkg) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			kept = append(kept, mt)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// TestSearchWithTypedWildcard drives a $x:io.Reader constraint through
+// search and filterTyped against a real type-checked fixture, end to end,
+// so a regression in nodeHasType's types.Eval call (e.g. losing the FileSet
+// or position a dotted identifier like io.Reader needs to resolve against)
+// is caught by go test rather than only by running the built binary.
+func TestSearchWithTypedWildcard(t *testing.T) {
+	const src = `package p
+
+import "io"
+
+func useReader(r io.Reader) {}
+
+func f(r io.Reader, s string) {
+	useReader(r)
+	useReader2(s)
+}
+
+func useReader2(s string) {}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "f.go", src, 0)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{Importer: importer.Default()}
+	pkg, err := conf.Check("p", fset, []*ast.File{file}, info)
+	if err != nil {
+		t.Fatalf("types.Check: %v", err)
+	}
+
+	exprStr, constraints := extractTypeConstraints("useReader($x:io.Reader)")
+	pattern := compile(t, exprStr)
+
+	matches := filterTyped(fset, search(pattern, file, info), constraints, info, pkg)
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1 (useReader(r), not useReader2(s))", len(matches))
+	}
+	arg, ok := matches[0].values["x"].(*ast.Ident)
+	if !ok || arg.Name != "r" {
+		t.Errorf("$x bound to %#v, want ident \"r\"", matches[0].values["x"])
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractTypeConstraints(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantExpr   string
+		wantCstrts wildcardConstraints
+	}{
+		{
+			in:         "$x",
+			wantExpr:   "$x",
+			wantCstrts: wildcardConstraints{},
+		},
+		{
+			in:         "$x:int",
+			wantExpr:   "$x",
+			wantCstrts: wildcardConstraints{"x": "int"},
+		},
+		{
+			in:         "$x:io.Reader == nil",
+			wantExpr:   "$x == nil",
+			wantCstrts: wildcardConstraints{"x": "io.Reader"},
+		},
+		{
+			in:         "$x:implements(io.Closer)",
+			wantExpr:   "$x",
+			wantCstrts: wildcardConstraints{"x": "implements(io.Closer)"},
+		},
+		{
+			in:         "f($x:assignable-to(io.Writer), $y:error)",
+			wantExpr:   "f($x, $y)",
+			wantCstrts: wildcardConstraints{"x": "assignable-to(io.Writer)", "y": "error"},
+		},
+		{
+			// a bare colon with nothing after it isn't a constraint
+			in:         "$x:",
+			wantExpr:   "$x:",
+			wantCstrts: wildcardConstraints{},
+		},
+	}
+	for _, test := range tests {
+		gotExpr, gotCstrts := extractTypeConstraints(test.in)
+		if gotExpr != test.wantExpr {
+			t.Errorf("extractTypeConstraints(%q) expr = %q, want %q", test.in, gotExpr, test.wantExpr)
+		}
+		if !reflect.DeepEqual(gotCstrts, test.wantCstrts) {
+			t.Errorf("extractTypeConstraints(%q) constraints = %#v, want %#v", test.in, gotCstrts, test.wantCstrts)
+		}
+	}
+}